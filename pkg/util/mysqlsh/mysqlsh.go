@@ -19,7 +19,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"regexp"
+	"os"
 	"strings"
 	"sync"
 
@@ -57,12 +57,120 @@ type Interface interface {
 	RebootClusterFromCompleteOutage(ctx context.Context) error
 }
 
-// errorRegex is used to parse Python tracebacks generated by mysql-shell.
-var errorRegex = regexp.MustCompile(`Traceback.*\n(?:  (.*)\n){1,}(?P<type>[\w\.]+)\: (?P<message>.*)`)
+// TLSConfig configures the TLS/mTLS settings mysqlsh uses for its own
+// connection to the target MySQL instance.
+type TLSConfig struct {
+	// Mode is passed as --ssl-mode, e.g. "REQUIRED", "VERIFY_CA" or
+	// "VERIFY_IDENTITY".
+	Mode string
+	// CACert is the path to a PEM-encoded CA certificate, passed as
+	// --ssl-ca.
+	CACert string
+	// Cert is the path to a PEM-encoded client certificate, passed as
+	// --ssl-cert.
+	Cert string
+	// Key is the path to the PEM-encoded private key for Cert, passed as
+	// --ssl-key.
+	Key string
+}
+
+// args returns the mysqlsh command-line flags for c, or nil if c is nil.
+func (c *TLSConfig) args() []string {
+	if c == nil {
+		return nil
+	}
+	var args []string
+	if c.Mode != "" {
+		args = append(args, "--ssl-mode", c.Mode)
+	}
+	if c.CACert != "" {
+		args = append(args, "--ssl-ca", c.CACert)
+	}
+	if c.Cert != "" {
+		args = append(args, "--ssl-cert", c.Cert)
+	}
+	if c.Key != "" {
+		args = append(args, "--ssl-key", c.Key)
+	}
+	return args
+}
+
+// Config configures a mysqlsh-backed Interface.
+type Config struct {
+	// URI is the Uniform Resource Identifier of the MySQL instance to
+	// connect to. Format: [user[:pass]]@host[:port][/db].
+	URI string
+	// TLS configures mysqlsh's own connection to URI. Nil disables TLS
+	// configuration, leaving mysqlsh/libmysqlclient defaults in effect.
+	TLS *TLSConfig
+	// Binary is the path to (or name of) the mysqlsh executable. Empty
+	// defaults to "mysqlsh" resolved via PATH. Use DetectBinary to probe
+	// the well-known install locations of distributions that don't put
+	// mysqlsh on PATH (e.g. snap packages).
+	Binary string
+	// Mode selects the scripting language used to drive mysqlsh. Empty
+	// defaults to ModePython.
+	Mode Mode
+}
+
+// Mode selects the scripting language mysqlsh uses to run the statements
+// generated by a runner.
+type Mode string
+
+const (
+	// ModePython drives mysqlsh via its --py interpreter. This is the
+	// default.
+	ModePython Mode = "py"
+	// ModeJS drives mysqlsh via its --js interpreter, for mysql-shell
+	// builds (e.g. some snap packages) that default to JavaScript and
+	// don't ship a Python interpreter.
+	ModeJS Mode = "js"
+)
+
+// defaultBinaryPaths are the well-known mysqlsh install locations probed
+// by DetectBinary, in order.
+var defaultBinaryPaths = []string{
+	"/usr/bin/mysqlsh",
+	"/snap/bin/mysqlsh",
+	"/snap/bin/mysql-shell.mysqlsh",
+	"/snap/mysql-shell/current/usr/bin/mysqlsh",
+}
+
+// DetectBinary returns the first mysqlsh binary found among
+// defaultBinaryPaths, or "mysqlsh" (resolved via PATH at exec time) if
+// none of them exist.
+func DetectBinary() string {
+	for _, path := range defaultBinaryPaths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return "mysqlsh"
+}
+
+// rebootClusterStatements are the statements used to manually reboot a
+// cluster from a complete outage. Shared between the mysqlsh-based runner
+// and the native SQL runner.
+//
+// NOTE(apryde): This is implemented in SQL rather than as a call to
+// dba.reboot_cluster_from_complete_outage() due to
+// https://bugs.mysql.com/90793.
+var rebootClusterStatements = []string{
+	"RESET PERSIST group_replication_bootstrap_group",
+	"SET GLOBAL group_replication_bootstrap_group=ON",
+	"start group_replication",
+}
 
 // New creates a new MySQL Shell Interface.
 func New(exec utilexec.Interface, uri string) Interface {
-	return &runner{exec: exec, uri: uri}
+	return NewWithConfig(exec, Config{URI: uri})
+}
+
+// NewWithConfig creates a new MySQL Shell Interface using cfg, allowing
+// TLS/mTLS, the mysqlsh binary path and the scripting Mode to be
+// configured.
+func NewWithConfig(exec utilexec.Interface, cfg Config) Interface {
+	return &runner{exec: exec, uri: cfg.URI, tls: cfg.TLS, binary: cfg.Binary, mode: cfg.Mode}
 }
 
 // runner implements Interface in terms of exec("mysqlsh").
@@ -73,35 +181,45 @@ type runner struct {
 	// uri is Uniform Resource Identifier of the MySQL instance to connect to.
 	// Format: [user[:pass]]@host[:port][/db].
 	uri string
+
+	// tls configures mysqlsh's own connection to uri. May be nil.
+	tls *TLSConfig
+
+	// binary is the path to (or name of) the mysqlsh executable.
+	binary string
+
+	// mode selects the scripting language used to drive mysqlsh.
+	mode Mode
+}
+
+// WithBinary sets the path to (or name of) the mysqlsh executable r
+// invokes, overriding the default of "mysqlsh" resolved via PATH.
+func (r *runner) WithBinary(path string) *runner {
+	r.binary = path
+	return r
 }
 
 func (r *runner) IsClustered(ctx context.Context) bool {
-	python := fmt.Sprintf("dba.get_cluster('%s')", innodb.DefaultClusterName)
-	_, err := r.run(ctx, python)
+	getCluster := fmt.Sprintf("dba.get_cluster('%s')", innodb.DefaultClusterName)
+	if r.scriptMode() == ModeJS {
+		getCluster = fmt.Sprintf("dba.getCluster('%s')", innodb.DefaultClusterName)
+	}
+	_, err := r.run(ctx, getCluster)
 	return err == nil
 }
 
 func (r *runner) CreateCluster(ctx context.Context, opts Options) (*innodb.ClusterStatus, error) {
-	python := fmt.Sprintf("print dba.create_cluster('%s', %s).status()", innodb.DefaultClusterName, opts)
-	output, err := r.run(ctx, python)
+	createCluster := fmt.Sprintf("dba.create_cluster('%s', %s).status()", innodb.DefaultClusterName, opts)
+	if r.scriptMode() == ModeJS {
+		createCluster = fmt.Sprintf("dba.createCluster('%s', %s).status()", innodb.DefaultClusterName, opts)
+	}
+	output, err := r.run(ctx, createCluster)
 	if err != nil {
 		return nil, err
 	}
 
-	// Skip non-json spat out on stdout.
-	var jsonData string
-	for _, line := range strings.Split(string(output), "\n") {
-		if strings.HasPrefix(line, "{") {
-			jsonData = line
-			break
-		}
-	}
-	if jsonData == "" {
-		return nil, errors.Errorf("no json found in output: %q", output)
-	}
-
 	status := &innodb.ClusterStatus{}
-	err = json.Unmarshal([]byte(jsonData), status)
+	err = json.Unmarshal(output, status)
 	if err != nil {
 		return nil, errors.Wrapf(err, "decoding cluster status output: %q", output)
 	}
@@ -109,8 +227,11 @@ func (r *runner) CreateCluster(ctx context.Context, opts Options) (*innodb.Clust
 }
 
 func (r *runner) GetClusterStatus(ctx context.Context) (*innodb.ClusterStatus, error) {
-	python := fmt.Sprintf("print dba.get_cluster('%s').status()", innodb.DefaultClusterName)
-	output, err := r.run(ctx, python)
+	getStatus := fmt.Sprintf("dba.get_cluster('%s').status()", innodb.DefaultClusterName)
+	if r.scriptMode() == ModeJS {
+		getStatus = fmt.Sprintf("dba.getCluster('%s').status()", innodb.DefaultClusterName)
+	}
+	output, err := r.run(ctx, getStatus)
 	if err != nil {
 		return nil, err
 	}
@@ -125,8 +246,11 @@ func (r *runner) GetClusterStatus(ctx context.Context) (*innodb.ClusterStatus, e
 }
 
 func (r *runner) CheckInstanceState(ctx context.Context, uri string) (*innodb.InstanceState, error) {
-	python := fmt.Sprintf("print dba.get_cluster('%s').check_instance_state('%s')", innodb.DefaultClusterName, uri)
-	output, err := r.run(ctx, python)
+	checkState := fmt.Sprintf("dba.get_cluster('%s').check_instance_state('%s')", innodb.DefaultClusterName, uri)
+	if r.scriptMode() == ModeJS {
+		checkState = fmt.Sprintf("dba.getCluster('%s').checkInstanceState('%s')", innodb.DefaultClusterName, uri)
+	}
+	output, err := r.run(ctx, checkState)
 	if err != nil {
 		return nil, err
 	}
@@ -142,18 +266,27 @@ func (r *runner) CheckInstanceState(ctx context.Context, uri string) (*innodb.In
 
 func (r *runner) AddInstanceToCluster(ctx context.Context, uri string, opts Options) error {
 	python := fmt.Sprintf("dba.get_cluster('%s').add_instance('%s', %s)", innodb.DefaultClusterName, uri, opts)
+	if r.scriptMode() == ModeJS {
+		python = fmt.Sprintf("dba.getCluster('%s').addInstance('%s', %s)", innodb.DefaultClusterName, uri, opts)
+	}
 	_, err := r.run(ctx, python)
 	return err
 }
 
 func (r *runner) RejoinInstanceToCluster(ctx context.Context, uri string, opts Options) error {
 	python := fmt.Sprintf("dba.get_cluster('%s').rejoin_instance('%s', %s)", innodb.DefaultClusterName, uri, opts)
+	if r.scriptMode() == ModeJS {
+		python = fmt.Sprintf("dba.getCluster('%s').rejoinInstance('%s', %s)", innodb.DefaultClusterName, uri, opts)
+	}
 	_, err := r.run(ctx, python)
 	return err
 }
 
 func (r *runner) RemoveInstanceFromCluster(ctx context.Context, uri string, opts Options) error {
 	python := fmt.Sprintf("dba.get_cluster('%s').remove_instance('%s', %s)", innodb.DefaultClusterName, uri, opts)
+	if r.scriptMode() == ModeJS {
+		python = fmt.Sprintf("dba.getCluster('%s').removeInstance('%s', %s)", innodb.DefaultClusterName, uri, opts)
+	}
 	_, err := r.run(ctx, python)
 	return err
 }
@@ -172,24 +305,43 @@ func (r *runner) run(ctx context.Context, python string) ([]byte, error) {
 	stdout := &bytes.Buffer{}
 	stderr := &bytes.Buffer{}
 
-	args := []string{"--no-wizard", "--uri", r.uri, "--py", "-e", python}
+	args := append([]string{"--no-wizard", "--uri", r.uri}, r.tls.args()...)
+	args = append(args, "--json=raw", "--"+string(r.scriptMode()), "-e", python)
 
-	cmd := r.exec.CommandContext(ctx, "mysqlsh", args...)
+	cmd := r.exec.CommandContext(ctx, r.mysqlshBinary(), args...)
 
 	cmd.SetStdout(stdout)
 	cmd.SetStderr(stderr)
 
-	glog.V(6).Infof("Running command: mysqlsh %v", args)
-	err := cmd.Run()
-	glog.V(6).Infof("    stdout: %s\n    stderr: %s\n    err: %s", stdout, stderr, err)
+	glog.V(6).Infof("Running command: %s %v", r.mysqlshBinary(), args)
+	runErr := cmd.Run()
+	glog.V(6).Infof("    stdout: %s\n    stderr: %s\n    err: %s", stdout, stderr, runErr)
+
+	result, err := parseJSONStream(r.stripPasswordWarning(stdout.Bytes()))
 	if err != nil {
-		underlying := NewErrorFromStderr(stderr.String())
-		if underlying != nil {
-			return nil, errors.WithStack(underlying)
-		}
+		return nil, err
+	}
+	if result == nil && runErr != nil {
+		return nil, errors.WithStack(runErr)
 	}
+	return result, nil
+}
 
-	return r.stripPasswordWarning(stdout.Bytes()), err
+// mysqlshBinary returns the mysqlsh executable to invoke, defaulting to
+// "mysqlsh" resolved via PATH if r.binary is unset.
+func (r *runner) mysqlshBinary() string {
+	if r.binary == "" {
+		return "mysqlsh"
+	}
+	return r.binary
+}
+
+// scriptMode returns r.mode, defaulting to ModePython if unset.
+func (r *runner) scriptMode() Mode {
+	if r.mode == "" {
+		return ModePython
+	}
+	return r.mode
 }
 
 func (r *runner) RebootClusterFromCompleteOutage(ctx context.Context) error {
@@ -199,36 +351,81 @@ func (r *runner) RebootClusterFromCompleteOutage(ctx context.Context) error {
 	stdout := &bytes.Buffer{}
 	stderr := &bytes.Buffer{}
 
-	// NOTE(apryde): This is implemented in SQL rather than as a call to
-	// dba.reboot_cluster_from_complete_outage() due to https://bugs.mysql.com/90793.
-	sql := strings.Join([]string{
-		"RESET PERSIST group_replication_bootstrap_group;",
-		"SET GLOBAL group_replication_bootstrap_group=ON;",
-		"start group_replication;",
-	}, " ")
+	sql := strings.Join(rebootClusterStatements, "; ") + ";"
 
-	args := []string{"--no-wizard", "--uri", r.uri, "--sql", "-e", sql}
+	args := append([]string{"--no-wizard", "--uri", r.uri}, r.tls.args()...)
+	args = append(args, "--json=raw", "--sql", "-e", sql)
 
-	cmd := r.exec.CommandContext(ctx, "mysqlsh", args...)
+	cmd := r.exec.CommandContext(ctx, r.mysqlshBinary(), args...)
 
 	cmd.SetStdout(stdout)
 	cmd.SetStderr(stderr)
 
-	glog.V(6).Infof("Running command: mysqlsh %v", args)
-	err := cmd.Run()
-	glog.V(6).Infof("    stdout: %s\n    stderr: %s\n    err: %s", stdout, stderr, err)
+	glog.V(6).Infof("Running command: %s %v", r.mysqlshBinary(), args)
+	runErr := cmd.Run()
+	glog.V(6).Infof("    stdout: %s\n    stderr: %s\n    err: %s", stdout, stderr, runErr)
+
+	result, err := parseJSONStream(r.stripPasswordWarning(stdout.Bytes()))
 	if err != nil {
-		underlying := NewErrorFromStderr(stderr.String())
-		if underlying != nil {
-			return errors.WithStack(underlying)
+		return err
+	}
+	if result == nil && runErr != nil {
+		return errors.WithStack(runErr)
+	}
+	return nil
+}
+
+// jsonEvent is one newline-delimited JSON object emitted by mysqlsh when
+// run with --json=raw: either the "result" of the evaluated statement, or
+// an "error" describing why it failed.
+type jsonEvent struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *jsonError      `json:"error,omitempty"`
+}
+
+// jsonError is the "error" member of a jsonEvent.
+type jsonError struct {
+	Code    int    `json:"code"`
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// parseJSONStream parses the newline-delimited JSON objects emitted by
+// mysqlsh when run with --json=raw, returning the last "result" payload
+// seen. If any event carries a structured "error", that takes precedence
+// and is returned as an *Error.
+func parseJSONStream(output []byte) ([]byte, error) {
+	var result []byte
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		var event jsonEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			// Not a recognised event (e.g. a warning banner); ignore it.
+			continue
+		}
+		if event.Error != nil {
+			return nil, errors.WithStack(&Error{
+				Code:    event.Error.Code,
+				Type:    event.Error.Type,
+				Message: event.Error.Message,
+			})
+		}
+		if event.Result != nil {
+			result = event.Result
 		}
 	}
-	return err
+	return result, nil
 }
 
 // Error holds errors from mysql-shell commands.
 type Error struct {
 	error
+	// Code is the underlying MySQL Shell / MySQL error code, as reported
+	// in mysqlsh's --json=raw error events. 0 if unknown.
+	Code    int
 	Type    string
 	Message string
 }
@@ -237,21 +434,29 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("%s: %s", e.Type, e.Message)
 }
 
-// NewErrorFromStderr parses the given output from mysql-shell into an Error if
-// one is present.
-func NewErrorFromStderr(stderr string) error {
-	matches := errorRegex.FindAllStringSubmatch(stderr, -1)
-	if len(matches) == 0 {
-		return nil
-	}
-	result := make(map[string]string)
-	for i, name := range errorRegex.SubexpNames() {
-		if i != 0 && name != "" {
-			result[name] = matches[len(matches)-1][i]
-		}
-	}
-	return &Error{
-		Type:    result["type"],
-		Message: result["message"],
+// Is implements the interface consulted by errors.Is. Two *Error values
+// are considered equal if they carry the same non-zero Code, so callers
+// can test a returned error against the Err* sentinels below.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
 	}
+	return e.Code != 0 && e.Code == t.Code
 }
+
+// Sentinel errors for well-known mysqlsh / AdminAPI error codes, testable
+// with errors.Is(err, mysqlsh.ErrNoQuorum) and friends.
+var (
+	// ErrInstanceAlreadyInCluster indicates the target instance is
+	// already a member of the cluster.
+	ErrInstanceAlreadyInCluster = &Error{Code: 51314, Type: "MYSQLSH", Message: "instance already in cluster"}
+	// ErrInstanceUnreachable indicates the target instance could not be
+	// reached.
+	ErrInstanceUnreachable = &Error{Code: 51118, Type: "MYSQLSH", Message: "instance unreachable"}
+	// ErrMetadataMissing indicates the InnoDB Cluster metadata schema is
+	// missing or uninitialized on the target instance.
+	ErrMetadataMissing = &Error{Code: 51309, Type: "MYSQLSH", Message: "metadata missing"}
+	// ErrNoQuorum indicates the cluster cannot reach quorum.
+	ErrNoQuorum = &Error{Code: 51132, Type: "MYSQLSH", Message: "no quorum"}
+)
@@ -0,0 +1,47 @@
+// Copyright 2018 Oracle and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysqlsh
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Options holds the tunable parameters passed through to the dba.*
+// cluster/instance operations (e.g. dba.create_cluster(),
+// .add_instance(), .rejoin_instance()).
+type Options struct {
+	// MemberSslMode controls whether Group Replication recovery and group
+	// communication traffic is encrypted: "DISABLED", "REQUIRED" or
+	// "AUTO". Empty leaves it at the server default.
+	MemberSslMode string
+	// IPWhitelist restricts which hosts may participate in Group
+	// Replication, e.g. "10.0.0.0/8,192.168.1.0/24". Empty leaves it at
+	// the server default.
+	IPWhitelist string
+}
+
+// String renders o as the Python dict literal expected by the dba.*
+// calls, e.g. dba.create_cluster('name', {'memberSslMode': 'REQUIRED'}).
+func (o Options) String() string {
+	var fields []string
+	if o.MemberSslMode != "" {
+		fields = append(fields, fmt.Sprintf("'memberSslMode': '%s'", o.MemberSslMode))
+	}
+	if o.IPWhitelist != "" {
+		fields = append(fields, fmt.Sprintf("'ipWhitelist': '%s'", o.IPWhitelist))
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
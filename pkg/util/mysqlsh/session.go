@@ -0,0 +1,298 @@
+// Copyright 2018 Oracle and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysqlsh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/oracle/mysql-operator/pkg/cluster/innodb"
+)
+
+// Session is a long-lived mysqlsh process that multiple statements can be
+// issued against, amortizing mysql-shell's ~1s startup cost across a
+// whole reconcile instead of paying it once per Interface call. Its
+// method set mirrors Interface.
+type Session interface {
+	IsClustered(ctx context.Context) bool
+	CreateCluster(ctx context.Context, opts Options) (*innodb.ClusterStatus, error)
+	GetClusterStatus(ctx context.Context) (*innodb.ClusterStatus, error)
+	CheckInstanceState(ctx context.Context, uri string) (*innodb.InstanceState, error)
+	AddInstanceToCluster(ctx context.Context, uri string, opts Options) error
+	RejoinInstanceToCluster(ctx context.Context, uri string, opts Options) error
+	RemoveInstanceFromCluster(ctx context.Context, uri string, opts Options) error
+	RebootClusterFromCompleteOutage(ctx context.Context) error
+	// Close terminates the underlying mysqlsh process. Further calls on
+	// the Session are invalid once Close has been called.
+	Close() error
+}
+
+// SessionOpener is implemented by Interface implementations that support
+// Session. The mysqlsh-based runner implements it; the SQL-based runner
+// does not need to, since a *sql.DB connection pool already amortizes
+// per-operation overhead.
+type SessionOpener interface {
+	Session(ctx context.Context) (Session, error)
+}
+
+// Session starts a single long-lived "mysqlsh --interactive=full" process
+// and returns a Session that issues statements against it over its
+// stdin/stdout, rather than spawning a fresh mysqlsh process per
+// statement.
+func (r *runner) Session(ctx context.Context) (Session, error) {
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+
+	args := append([]string{"--no-wizard", "--uri", r.uri}, r.tls.args()...)
+	args = append(args, "--json=raw", "--"+string(r.scriptMode()), "--interactive=full")
+
+	cmd := r.exec.CommandContext(ctx, r.mysqlshBinary(), args...)
+	cmd.SetStdin(stdinR)
+	cmd.SetStdout(stdoutW)
+	cmd.SetStderr(ioutil.Discard)
+
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "starting mysqlsh session")
+	}
+
+	return &session{
+		cmd:    cmd,
+		stdin:  stdinW,
+		stdout: bufio.NewReader(stdoutR),
+		mode:   r.scriptMode(),
+	}, nil
+}
+
+// session implements Session in terms of a single interactive mysqlsh
+// subprocess.
+type session struct {
+	mu     sync.Mutex
+	cmd    utilexecCmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	mode   Mode
+}
+
+// utilexecCmd is the subset of utilexec.Cmd that session needs. Declared
+// locally so session doesn't have to import k8s.io/utils/exec just for
+// this one interface reference.
+type utilexecCmd interface {
+	Wait() error
+}
+
+func (s *session) IsClustered(ctx context.Context) bool {
+	stmt := fmt.Sprintf("dba.get_cluster('%s')", innodb.DefaultClusterName)
+	if s.mode == ModeJS {
+		stmt = fmt.Sprintf("dba.getCluster('%s')", innodb.DefaultClusterName)
+	}
+	_, err := s.eval(stmt)
+	return err == nil
+}
+
+func (s *session) CreateCluster(ctx context.Context, opts Options) (*innodb.ClusterStatus, error) {
+	stmt := fmt.Sprintf("dba.create_cluster('%s', %s).status()", innodb.DefaultClusterName, opts)
+	if s.mode == ModeJS {
+		stmt = fmt.Sprintf("dba.createCluster('%s', %s).status()", innodb.DefaultClusterName, opts)
+	}
+	output, err := s.eval(stmt)
+	if err != nil {
+		return nil, err
+	}
+	status := &innodb.ClusterStatus{}
+	if err := json.Unmarshal(output, status); err != nil {
+		return nil, errors.Wrapf(err, "decoding cluster status output: %q", output)
+	}
+	return status, nil
+}
+
+func (s *session) GetClusterStatus(ctx context.Context) (*innodb.ClusterStatus, error) {
+	stmt := fmt.Sprintf("dba.get_cluster('%s').status()", innodb.DefaultClusterName)
+	if s.mode == ModeJS {
+		stmt = fmt.Sprintf("dba.getCluster('%s').status()", innodb.DefaultClusterName)
+	}
+	output, err := s.eval(stmt)
+	if err != nil {
+		return nil, err
+	}
+	status := &innodb.ClusterStatus{}
+	if err := json.Unmarshal(output, status); err != nil {
+		return nil, errors.Wrapf(err, "decoding cluster status output: %q", output)
+	}
+	return status, nil
+}
+
+func (s *session) CheckInstanceState(ctx context.Context, uri string) (*innodb.InstanceState, error) {
+	stmt := fmt.Sprintf("dba.get_cluster('%s').check_instance_state('%s')", innodb.DefaultClusterName, uri)
+	if s.mode == ModeJS {
+		stmt = fmt.Sprintf("dba.getCluster('%s').checkInstanceState('%s')", innodb.DefaultClusterName, uri)
+	}
+	output, err := s.eval(stmt)
+	if err != nil {
+		return nil, err
+	}
+	state := &innodb.InstanceState{}
+	if err := json.Unmarshal(output, state); err != nil {
+		return nil, errors.Wrapf(err, "decoding instance state output: %q", output)
+	}
+	return state, nil
+}
+
+func (s *session) AddInstanceToCluster(ctx context.Context, uri string, opts Options) error {
+	stmt := fmt.Sprintf("dba.get_cluster('%s').add_instance('%s', %s)", innodb.DefaultClusterName, uri, opts)
+	if s.mode == ModeJS {
+		stmt = fmt.Sprintf("dba.getCluster('%s').addInstance('%s', %s)", innodb.DefaultClusterName, uri, opts)
+	}
+	_, err := s.eval(stmt)
+	return err
+}
+
+func (s *session) RejoinInstanceToCluster(ctx context.Context, uri string, opts Options) error {
+	stmt := fmt.Sprintf("dba.get_cluster('%s').rejoin_instance('%s', %s)", innodb.DefaultClusterName, uri, opts)
+	if s.mode == ModeJS {
+		stmt = fmt.Sprintf("dba.getCluster('%s').rejoinInstance('%s', %s)", innodb.DefaultClusterName, uri, opts)
+	}
+	_, err := s.eval(stmt)
+	return err
+}
+
+func (s *session) RemoveInstanceFromCluster(ctx context.Context, uri string, opts Options) error {
+	stmt := fmt.Sprintf("dba.get_cluster('%s').remove_instance('%s', %s)", innodb.DefaultClusterName, uri, opts)
+	if s.mode == ModeJS {
+		stmt = fmt.Sprintf("dba.getCluster('%s').removeInstance('%s', %s)", innodb.DefaultClusterName, uri, opts)
+	}
+	_, err := s.eval(stmt)
+	return err
+}
+
+// RebootClusterFromCompleteOutage switches the session to \sql mode for
+// rebootClusterStatements (see the NOTE on that var), then switches back.
+func (s *session) RebootClusterFromCompleteOutage(ctx context.Context) error {
+	if err := s.evalSQL(`\sql`); err != nil {
+		return err
+	}
+	for _, stmt := range rebootClusterStatements {
+		if err := s.evalSQL(stmt); err != nil {
+			return err
+		}
+	}
+	_, err := s.eval(`\` + string(s.mode))
+	return err
+}
+
+// Close terminates the underlying mysqlsh process.
+func (s *session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stdin.Close()
+	return s.cmd.Wait()
+}
+
+// sessionMarker is appended, as its own statement, after every statement
+// eval/evalSQL sends. Plenty of valid statements produce no "result"
+// event of their own (the \sql/\py/\js mode-switch meta-commands chief
+// among them, since RebootClusterFromCompleteOutage uses \sql), so
+// waiting for the first '{' line would block forever; the marker
+// statement always produces one, giving eval/evalSQL a reliable end of
+// a stmt's output to read up to.
+const sessionMarker = "__mysqlsh_session_eval_marker__"
+
+// pyMarkerStmt is a single-quoted string literal, valid as a standalone
+// statement in both of mysqlsh's --py and --js interpreters.
+var pyMarkerStmt = fmt.Sprintf("'%s'", sessionMarker)
+
+// sqlMarkerStmt is the marker statement to send while in \sql mode.
+var sqlMarkerStmt = fmt.Sprintf("SELECT '%s'", sessionMarker)
+
+// eval writes stmt to the session's stdin and returns the "result"
+// payload of the last JSON event seen before the marker, skipping any
+// non-JSON lines (banners, prompts). An "error" event is returned as an
+// *Error. eval assumes the session is currently in its py/js mode; use
+// evalSQL for statements issued while in \sql mode (including the \sql
+// switch itself).
+func (s *session) eval(stmt string) ([]byte, error) {
+	return s.evalMarked(stmt, pyMarkerStmt)
+}
+
+// evalSQL is eval for statements issued while the session is in \sql
+// mode, where the marker statement must be valid SQL rather than a
+// Python/JS literal.
+func (s *session) evalSQL(stmt string) error {
+	_, err := s.evalMarked(stmt, sqlMarkerStmt)
+	return err
+}
+
+// evalMarked writes stmt followed by markerStmt, and returns the
+// "result" payload of the last JSON event seen for stmt. Reading stops
+// as soon as a line containing sessionMarker is seen, regardless of
+// whether it parses as JSON, since the shape of a marker's own output
+// differs between py/js (a quoted string) and SQL (a tabular result)
+// mode.
+func (s *session) evalMarked(stmt, markerStmt string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := fmt.Fprintln(s.stdin, stmt); err != nil {
+		return nil, errors.Wrap(err, "writing to mysqlsh session")
+	}
+	if _, err := fmt.Fprintln(s.stdin, markerStmt); err != nil {
+		return nil, errors.Wrap(err, "writing marker to mysqlsh session")
+	}
+
+	var result []byte
+	var evalErr error
+	for {
+		line, err := s.stdout.ReadBytes('\n')
+		if err != nil {
+			return nil, errors.Wrap(err, "reading from mysqlsh session")
+		}
+		line = bytes.TrimSpace(line)
+		if bytes.Contains(line, []byte(sessionMarker)) {
+			if evalErr != nil {
+				return nil, evalErr
+			}
+			return result, nil
+		}
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		var event jsonEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			continue
+		}
+		if event.Error != nil {
+			// Keep reading up to the marker so the next call starts on
+			// its own statement's output.
+			if evalErr == nil {
+				evalErr = errors.WithStack(&Error{
+					Code:    event.Error.Code,
+					Type:    event.Error.Type,
+					Message: event.Error.Message,
+				})
+			}
+			continue
+		}
+		if event.Result != nil {
+			result = event.Result
+		}
+	}
+}
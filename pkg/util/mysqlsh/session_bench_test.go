@@ -0,0 +1,198 @@
+// Copyright 2018 Oracle and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysqlsh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	utilexec "k8s.io/utils/exec"
+)
+
+// fakeSpawnOverhead stands in for the ~1s mysqlsh process startup cost
+// that motivates Session, scaled down so the benchmark runs quickly
+// while still being dominated by that per-call overhead rather than by
+// the (trivial) work each fake mysqlsh does.
+const fakeSpawnOverhead = 200 * time.Microsecond
+
+// fakeCmd is a utilexec.Cmd backed by an in-memory run function, standing
+// in for a real mysqlsh subprocess so the benchmarks below can drive the
+// real runner/session code instead of a hand-rolled Interface/Session.
+type fakeCmd struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	run    func(stdin io.Reader, stdout, stderr io.Writer) error
+	done   chan error
+}
+
+func (c *fakeCmd) SetDir(dir string)       {}
+func (c *fakeCmd) SetStdin(in io.Reader)   { c.stdin = in }
+func (c *fakeCmd) SetStdout(out io.Writer) { c.stdout = out }
+func (c *fakeCmd) SetStderr(out io.Writer) { c.stderr = out }
+func (c *fakeCmd) SetEnv(env []string)     {}
+func (c *fakeCmd) Stop()                   {}
+
+func (c *fakeCmd) Run() error {
+	return c.run(c.stdin, c.stdout, c.stderr)
+}
+
+func (c *fakeCmd) CombinedOutput() ([]byte, error) {
+	var buf bytes.Buffer
+	c.stdout, c.stderr = &buf, &buf
+	return buf.Bytes(), c.Run()
+}
+
+func (c *fakeCmd) Output() ([]byte, error) {
+	var buf bytes.Buffer
+	c.stdout = &buf
+	return buf.Bytes(), c.Run()
+}
+
+func (c *fakeCmd) Start() error {
+	c.done = make(chan error, 1)
+	go func() { c.done <- c.run(c.stdin, c.stdout, c.stderr) }()
+	return nil
+}
+
+func (c *fakeCmd) Wait() error {
+	return <-c.done
+}
+
+var _ utilexec.Cmd = (*fakeCmd)(nil)
+
+// fakeExec is a utilexec.Interface that hands every command to newCmd.
+type fakeExec struct {
+	newCmd func(cmd string, args ...string) *fakeCmd
+}
+
+func (f *fakeExec) Command(cmd string, args ...string) utilexec.Cmd {
+	return f.newCmd(cmd, args...)
+}
+
+func (f *fakeExec) CommandContext(ctx context.Context, cmd string, args ...string) utilexec.Cmd {
+	return f.newCmd(cmd, args...)
+}
+
+func (f *fakeExec) LookPath(file string) (string, error) {
+	return file, nil
+}
+
+var _ utilexec.Interface = (*fakeExec)(nil)
+
+// fakeOneShotCmd simulates a single "mysqlsh --json=raw -e ..." run: pay
+// the startup cost, then print a canned empty result, the way the real
+// binary's --json=raw framing looks for a statement with no interesting
+// payload.
+func fakeOneShotCmd(cmd string, args ...string) *fakeCmd {
+	return &fakeCmd{
+		run: func(stdin io.Reader, stdout, stderr io.Writer) error {
+			time.Sleep(fakeSpawnOverhead)
+			fmt.Fprintln(stdout, `{"result": {}}`)
+			return nil
+		},
+	}
+}
+
+// fakeSessionCmd simulates a single "mysqlsh --interactive=full" process:
+// pay the startup cost once, then answer every line read from stdin with
+// a canned JSON result, and the session's own marker statements (see
+// sessionMarker in session.go) with a result containing that marker, so
+// session.evalMarked's read loop terminates the way it would against the
+// real binary.
+func fakeSessionCmd(cmd string, args ...string) *fakeCmd {
+	return &fakeCmd{
+		run: func(stdin io.Reader, stdout, stderr io.Writer) error {
+			time.Sleep(fakeSpawnOverhead)
+			scanner := bufio.NewScanner(stdin)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if strings.Contains(line, sessionMarker) {
+					fmt.Fprintf(stdout, "{\"result\": \"%s\"}\n", sessionMarker)
+					continue
+				}
+				fmt.Fprintln(stdout, `{"result": {}}`)
+			}
+			return scanner.Err()
+		},
+	}
+}
+
+func fakeInstanceURIs(n int) []string {
+	uris := make([]string, n)
+	for i := range uris {
+		uris[i] = fmt.Sprintf("root@mysql-%d:3306", i)
+	}
+	return uris
+}
+
+// BenchmarkReconcile_PerCall reconciles a 5-node cluster the way runner
+// does today: a GetClusterStatus call plus one CheckInstanceState call
+// per instance, each spawning a fresh fake mysqlsh process and going
+// through the real runner.run/parseJSONStream code path.
+func BenchmarkReconcile_PerCall(b *testing.B) {
+	ctx := context.Background()
+	exec := &fakeExec{newCmd: fakeOneShotCmd}
+	instances := fakeInstanceURIs(5)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mysqlsh := New(exec, "root@mysql-0:3306")
+		if _, err := mysqlsh.GetClusterStatus(ctx); err != nil {
+			b.Fatal(err)
+		}
+		for _, uri := range instances {
+			if _, err := mysqlsh.CheckInstanceState(ctx, uri); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkReconcile_Session reconciles the same 5-node cluster through a
+// single Session, going through the real runner.Session/session.eval code
+// path against one fake long-lived mysqlsh process instead of one per
+// call.
+func BenchmarkReconcile_Session(b *testing.B) {
+	ctx := context.Background()
+	exec := &fakeExec{newCmd: fakeSessionCmd}
+	opener := New(exec, "root@mysql-0:3306").(SessionOpener)
+	instances := fakeInstanceURIs(5)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sess, err := opener.Session(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := sess.GetClusterStatus(ctx); err != nil {
+			b.Fatal(err)
+		}
+		for _, uri := range instances {
+			if _, err := sess.CheckInstanceState(ctx, uri); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := sess.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+// Copyright 2018 Oracle and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysqlsh
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/oracle/mysql-operator/pkg/cluster/innodb"
+)
+
+// NewSQL creates a new MySQL Shell Interface that talks to MySQL directly
+// over db (already connected to the instance identified by uri), issuing
+// the InnoDB Cluster / Group Replication primitives as SQL rather than
+// shelling out to mysqlsh. It is a drop-in alternative to New(exec, uri)
+// for callers that would rather avoid the cost and fragility of spawning
+// a mysql-shell process per operation. uri is also used as the clone
+// donor when AddInstanceToCluster provisions a new instance from this
+// one.
+func NewSQL(db *sql.DB, uri string) Interface {
+	return &sqlRunner{db: db, uri: uri}
+}
+
+// sqlRunner implements Interface in terms of a *sql.DB connected directly
+// to the target MySQL instance identified by uri.
+type sqlRunner struct {
+	db  *sql.DB
+	uri string
+}
+
+func (r *sqlRunner) IsClustered(ctx context.Context) bool {
+	var count int
+	row := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM performance_schema.replication_group_members")
+	if err := row.Scan(&count); err != nil {
+		return false
+	}
+	return count > 0
+}
+
+func (r *sqlRunner) CreateCluster(ctx context.Context, opts Options) (*innodb.ClusterStatus, error) {
+	// group_replication_group_name must be a valid UUID; it identifies
+	// the replication group on the wire and is unrelated to
+	// innodb.DefaultClusterName, which is just the metadata label we
+	// report back in GetClusterStatus.
+	statements := append([]string{
+		fmt.Sprintf("SET GLOBAL group_replication_group_name='%s'", uuid.New().String()),
+	}, groupReplicationOptionStatements(opts)...)
+	statements = append(statements,
+		"SET GLOBAL group_replication_bootstrap_group=ON",
+		"START GROUP_REPLICATION",
+		"SET GLOBAL group_replication_bootstrap_group=OFF",
+	)
+	if err := r.exec(ctx, statements); err != nil {
+		return nil, err
+	}
+	return r.GetClusterStatus(ctx)
+}
+
+func (r *sqlRunner) GetClusterStatus(ctx context.Context) (*innodb.ClusterStatus, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT MEMBER_HOST, MEMBER_PORT, MEMBER_STATE, MEMBER_ROLE
+		FROM performance_schema.replication_group_members`)
+	if err != nil {
+		return nil, mapSQLError(err)
+	}
+	defer rows.Close()
+
+	topology := map[string]interface{}{}
+	var primary string
+	for rows.Next() {
+		var host, state, role string
+		var port int
+		if err := rows.Scan(&host, &port, &state, &role); err != nil {
+			return nil, errors.Wrap(err, "scanning replication_group_members row")
+		}
+		address := fmt.Sprintf("%s:%d", host, port)
+		mode := "R/O"
+		if role == "PRIMARY" {
+			mode = "R/W"
+			primary = address
+		}
+		topology[address] = map[string]interface{}{
+			"address": address,
+			"mode":    mode,
+			"role":    "HA",
+			"status":  state,
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading replication_group_members")
+	}
+
+	status := map[string]interface{}{
+		"clusterName": innodb.DefaultClusterName,
+		"defaultReplicaSet": map[string]interface{}{
+			"name":     "default",
+			"primary":  primary,
+			"topology": topology,
+		},
+	}
+
+	return decodeClusterStatus(status)
+}
+
+func (r *sqlRunner) CheckInstanceState(ctx context.Context, uri string) (*innodb.InstanceState, error) {
+	dsn, err := dsnFromURI(uri)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing uri %q", uri)
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connecting to %q", uri)
+	}
+	defer db.Close()
+
+	var gtidExecuted string
+	row := db.QueryRowContext(ctx, "SELECT @@GLOBAL.gtid_executed")
+	if err := row.Scan(&gtidExecuted); err != nil {
+		return nil, mapSQLError(err)
+	}
+
+	reason, mysqlState := "new", "ok"
+	if gtidExecuted != "" {
+		var err error
+		reason, err = r.gtidReason(ctx, gtidExecuted)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "recoverable" {
+			mysqlState = "error"
+		}
+	}
+
+	state := map[string]interface{}{
+		"reason": reason,
+		"state":  mysqlState,
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshalling instance state")
+	}
+	out := &innodb.InstanceState{}
+	if err := json.Unmarshal(b, out); err != nil {
+		return nil, errors.Wrap(err, "decoding instance state")
+	}
+	return out, nil
+}
+
+// AddInstanceToCluster provisions the instance at uri from this instance
+// (r.uri) via CLONE INSTANCE FROM, then has it join the group. The clone
+// is run on the joiner, pulling from r.uri as the donor; running it
+// against r.db, as an earlier version of this code did, would have
+// cloned the primary from itself.
+func (r *sqlRunner) AddInstanceToCluster(ctx context.Context, uri string, opts Options) error {
+	donor, password, err := cloneSource(r.uri)
+	if err != nil {
+		return errors.Wrapf(err, "parsing donor uri %q", r.uri)
+	}
+
+	dsn, err := dsnFromURI(uri)
+	if err != nil {
+		return errors.Wrapf(err, "parsing uri %q", uri)
+	}
+
+	// CLONE INSTANCE FROM restarts the recipient's mysqld once the clone
+	// completes, so it's run on its own connection; any connection still
+	// open afterwards, including the one that issued it, is dead.
+	if err := cloneInstance(ctx, dsn, donor, password); err != nil {
+		return err
+	}
+
+	joiner, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return errors.Wrapf(err, "connecting to %q", uri)
+	}
+	defer joiner.Close()
+
+	statements := append(groupReplicationOptionStatements(opts), "START GROUP_REPLICATION")
+	return execStatements(ctx, joiner, statements)
+}
+
+// cloneInstance runs CLONE INSTANCE FROM donor IDENTIFIED BY password
+// against dsn, on a connection of its own since the clone restarts dsn's
+// mysqld once it completes. password is passed as a bound parameter
+// rather than interpolated, since it comes from r.uri and may contain
+// characters (quotes, backslashes) that would otherwise break out of the
+// statement's string literal.
+func cloneInstance(ctx context.Context, dsn, donor, password string) error {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return errors.Wrap(err, "connecting to clone recipient")
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return mapSQLError(err)
+	}
+	defer conn.Close()
+
+	stmt := fmt.Sprintf("CLONE INSTANCE FROM %s IDENTIFIED BY ?", donor)
+	if _, err := conn.ExecContext(ctx, stmt, password); err != nil {
+		return mapSQLError(err)
+	}
+	return nil
+}
+
+func (r *sqlRunner) RejoinInstanceToCluster(ctx context.Context, uri string, opts Options) error {
+	dsn, err := dsnFromURI(uri)
+	if err != nil {
+		return errors.Wrapf(err, "parsing uri %q", uri)
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return errors.Wrapf(err, "connecting to %q", uri)
+	}
+	defer db.Close()
+
+	statements := append(groupReplicationOptionStatements(opts), "START GROUP_REPLICATION")
+	return execStatements(ctx, db, statements)
+}
+
+// RemoveInstanceFromCluster ignores opts: memberSslMode/ipWhitelist
+// configure how an instance joins the group, and have nothing to apply
+// when leaving it.
+func (r *sqlRunner) RemoveInstanceFromCluster(ctx context.Context, uri string, opts Options) error {
+	dsn, err := dsnFromURI(uri)
+	if err != nil {
+		return errors.Wrapf(err, "parsing uri %q", uri)
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return errors.Wrapf(err, "connecting to %q", uri)
+	}
+	defer db.Close()
+
+	return execStatements(ctx, db, []string{"STOP GROUP_REPLICATION"})
+}
+
+func (r *sqlRunner) RebootClusterFromCompleteOutage(ctx context.Context) error {
+	return r.exec(ctx, rebootClusterStatements)
+}
+
+// exec runs statements against r.db in order, within a single connection,
+// stopping at the first error.
+func (r *sqlRunner) exec(ctx context.Context, statements []string) error {
+	return execStatements(ctx, r.db, statements)
+}
+
+// execStatements runs statements against db in order, within a single
+// connection, stopping at the first error.
+func execStatements(ctx context.Context, db *sql.DB, statements []string) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return mapSQLError(err)
+	}
+	defer conn.Close()
+
+	for _, stmt := range statements {
+		glog.V(6).Infof("Running statement: %s", stmt)
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return mapSQLError(err)
+		}
+	}
+	return nil
+}
+
+// groupReplicationOptionStatements returns the SET GLOBAL statements
+// needed to apply opts before START GROUP_REPLICATION, the SQL-level
+// equivalent of the memberSslMode/ipWhitelist dict the mysqlsh-based
+// runner passes straight through to dba.*.
+func groupReplicationOptionStatements(opts Options) []string {
+	var statements []string
+	if opts.MemberSslMode != "" {
+		statements = append(statements, fmt.Sprintf("SET GLOBAL group_replication_ssl_mode='%s'", opts.MemberSslMode))
+	}
+	if opts.IPWhitelist != "" {
+		statements = append(statements, fmt.Sprintf("SET GLOBAL group_replication_ip_whitelist='%s'", opts.IPWhitelist))
+	}
+	return statements
+}
+
+// cloneSource splits uri — mysqlsh's own [user[:pass]]@host[:port][/db]
+// format — into the quoted 'user'@'host':port literal and password that
+// CLONE INSTANCE FROM expects for its donor.
+func cloneSource(uri string) (source, password string, err error) {
+	userinfo, hostAndDB, ok := cutLast(uri, "@")
+	if !ok {
+		return "", "", errors.Errorf("missing '@' separating user info from host")
+	}
+	host, _, _ := cut(hostAndDB, "/")
+	if host == "" {
+		return "", "", errors.Errorf("missing host")
+	}
+	hostname, port, ok := cut(host, ":")
+	if !ok {
+		port = "3306"
+	}
+	user, pass, _ := cut(userinfo, ":")
+
+	return fmt.Sprintf("'%s'@'%s':%s", user, hostname, port), pass, nil
+}
+
+// gtidReason compares instanceGTIDs — an instance's own
+// @@GLOBAL.gtid_executed — against r.db's, to distinguish an instance
+// that's merely behind (and can recover by replaying the transactions
+// it's missing) from one with errant transactions the cluster never
+// applied, which recovery can't resolve.
+func (r *sqlRunner) gtidReason(ctx context.Context, instanceGTIDs string) (string, error) {
+	var subset bool
+	row := r.db.QueryRowContext(ctx, "SELECT GTID_SUBSET(?, @@GLOBAL.gtid_executed)", instanceGTIDs)
+	if err := row.Scan(&subset); err != nil {
+		return "", mapSQLError(err)
+	}
+	if subset {
+		return "recoverable", nil
+	}
+	return "diverged", nil
+}
+
+// dsnFromURI converts uri, in mysqlsh's own connection-string format
+// ([user[:pass]]@host[:port][/db]), into the DSN format expected by
+// go-sql-driver/mysql (user:pass@tcp(host:port)/db), so it can be passed
+// to mysql.ParseDSN/sql.Open.
+func dsnFromURI(uri string) (string, error) {
+	userinfo, hostAndDB, ok := cutLast(uri, "@")
+	if !ok {
+		return "", errors.Errorf("missing '@' separating user info from host")
+	}
+	if hostAndDB == "" {
+		return "", errors.Errorf("missing host")
+	}
+	host, db, _ := cut(hostAndDB, "/")
+	if host == "" {
+		return "", errors.Errorf("missing host")
+	}
+
+	dsn := fmt.Sprintf("%s@tcp(%s)/%s", userinfo, host, db)
+	if _, err := mysql.ParseDSN(dsn); err != nil {
+		return "", err
+	}
+	return dsn, nil
+}
+
+// cut splits s on the first occurrence of sep, like strings.Cut.
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// cutLast splits s on the last occurrence of sep, like cut but scanning
+// from the end; used for the '@' separator since a password component may
+// itself contain '@'.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// decodeClusterStatus round-trips v through JSON into an
+// innodb.ClusterStatus, mirroring the way the mysqlsh-based runner decodes
+// the output of dba.get_cluster().status().
+func decodeClusterStatus(v interface{}) (*innodb.ClusterStatus, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshalling cluster status")
+	}
+	status := &innodb.ClusterStatus{}
+	if err := json.Unmarshal(b, status); err != nil {
+		return nil, errors.Wrapf(err, "decoding cluster status: %q", b)
+	}
+	return status, nil
+}
+
+// mapSQLError wraps err, unwrapping a *mysql.MySQLError's code and message
+// into the same Error type returned by the mysqlsh-based runner so callers
+// can treat both Interface implementations identically.
+func mapSQLError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if merr, ok := err.(*mysql.MySQLError); ok {
+		return errors.WithStack(&Error{
+			Code:    int(merr.Number),
+			Type:    "MySQLError",
+			Message: merr.Message,
+		})
+	}
+	return errors.WithStack(err)
+}